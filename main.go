@@ -1,18 +1,27 @@
 package main
 
 import (
+	"fmt"
 	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
-	"sync"
 
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
 	"github.com/hashicorp/terraform-config-inspect/tfconfig"
 	"github.com/hashicorp/terraform/configs"
+	"github.com/spf13/cobra"
+	"github.com/yourbasic/graph"
+	"github.com/zclconf/go-cty/cty"
 	"golang.org/x/exp/slices"
+	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
 )
 
@@ -22,21 +31,70 @@ import (
 // This represents the root of the repository during Atlantis pre-workflow hook execution.
 var ROOT = os.Getenv("DIR")
 
+// If set, enables "smart mode": only projects affected by files changed since BASE_REF
+// are included in the generated config. BASE_REF is passed straight to `git diff`, so it
+// can be a branch, tag, or commit (e.g. "main" or "origin/main").
+var BASE_REF = os.Getenv("BASE_REF")
+
+const defaultNumExecutors = 15
+
+// The maximum number of projects that addProjectsToConfig will process concurrently.
+var NUM_EXECUTORS = getNumExecutors()
+
 var IGNORE_DIRS = []string{".circleci", ".git", ".github", ".terraform"}
 
+// Glob pattern, relative to ROOT, used to scope project discovery to a subtree.
+// An empty FILTER (the default) means every discovered project is kept.
+var FILTER = ""
+
+// Atlantis workflow attached to every generated project. An empty WORKFLOW
+// (the default) omits the `workflow` key, leaving Atlantis' own default in effect.
+var WORKFLOW = ""
+
+// Whether autoplan is enabled for generated projects.
+var AUTOPLAN_ENABLED = true
+
+// When set, overrides AtlantisConfig.ParallelPlan / ParallelApply as read from
+// atlantis.yaml. Left nil unless the corresponding CLI flag was explicitly passed.
+var PARALLEL_PLAN_OVERRIDE *bool
+var PARALLEL_APPLY_OVERRIDE *bool
+
+// Parsed --config input file (defaults plus glob-matched per-path overrides),
+// or nil if --config wasn't passed.
+var CONFIG *GeneratorConfig
+
+// Name of the optional per-directory override file discovered during the walk.
+const projectOverrideFilename = ".atlantis.project.yaml"
+
+// Name of the Terragrunt config file that marks a directory as a Terragrunt project.
+const terragruntConfigFilename = "terragrunt.hcl"
+
+// If set, the `dependencies { paths = [...] }` block of a terragrunt.hcl is not
+// added to the dependency graph. It's commonly used only to order applies
+// rather than to declare file-change dependencies, so it can be noisy for
+// when_modified purposes; `dependency "x" { ... }` blocks are unaffected.
+var IGNORE_DEPENDENCY_BLOCKS = false
+
 type AtlantisConfig struct {
-	Automerge                 bool            `yaml:"automerge"`
-	DeleteSourceBranchOnMerge bool            `yaml:"delete_source_branch_on_merge"`
-	ParallelApply             bool            `yaml:"parallel_apply"`
-	ParallelPlan              bool            `yaml:"parallel_plan"`
-	Projects                  []ProjectConfig `yaml:"projects"`
-	Version                   int             `yaml:"version"`
+	Automerge                 bool                `yaml:"automerge"`
+	DeleteSourceBranchOnMerge bool                `yaml:"delete_source_branch_on_merge"`
+	ParallelApply             bool                `yaml:"parallel_apply"`
+	ParallelPlan              bool                `yaml:"parallel_plan"`
+	Projects                  []ProjectConfig     `yaml:"projects"`
+	Version                   int                 `yaml:"version"`
+	Workflows                 map[string]Workflow `yaml:"workflows,omitempty"`
 }
 
 type ProjectConfig struct {
-	Autoplan AutoplanConfig `yaml:"autoplan"`
-	Name     string         `yaml:"name"`
-	Dir      string         `yaml:"dir"`
+	Autoplan            AutoplanConfig   `yaml:"autoplan"`
+	Name                string           `yaml:"name"`
+	Dir                 string           `yaml:"dir"`
+	Workflow            string           `yaml:"workflow,omitempty"`
+	TerraformVersion    string           `yaml:"terraform_version,omitempty"`
+	ApplyRequirements   []string         `yaml:"apply_requirements,omitempty"`
+	ExecutionOrderGroup *int             `yaml:"execution_order_group,omitempty"`
+	RepoLocks           *RepoLocksConfig `yaml:"repo_locks,omitempty"`
+	Branch              string           `yaml:"branch,omitempty"`
 }
 
 type AutoplanConfig struct {
@@ -44,6 +102,62 @@ type AutoplanConfig struct {
 	WhenModified []string `yaml:"when_modified"`
 }
 
+type RepoLocksConfig struct {
+	Mode string `yaml:"mode"`
+}
+
+// A single named Atlantis workflow, as referenced by ProjectConfig.Workflow.
+type Workflow struct {
+	Plan    *WorkflowStage `yaml:"plan,omitempty"`
+	Apply   *WorkflowStage `yaml:"apply,omitempty"`
+	Import  *WorkflowStage `yaml:"import,omitempty"`
+	StateRm *WorkflowStage `yaml:"state_rm,omitempty"`
+}
+
+// A stage (plan/apply/import/state_rm) of a workflow. Steps are left as
+// interface{} since Atlantis accepts both plain strings ("init", "plan") and
+// maps with extra args (e.g. {run: "echo hi"}), and we just pass them through.
+type WorkflowStage struct {
+	Steps []interface{} `yaml:"steps,omitempty"`
+}
+
+// GeneratorConfig is the optional --config input file: defaults applied to
+// every project, plus a list of glob-matched overrides applied on top, in
+// order, with the first matching glob winning.
+type GeneratorConfig struct {
+	Defaults  ProjectOverride     `yaml:"defaults"`
+	Overrides []ProjectOverride   `yaml:"overrides"`
+	Workflows map[string]Workflow `yaml:"workflows,omitempty"`
+}
+
+// ProjectOverride holds the subset of ProjectConfig that can be set via
+// GeneratorConfig.Overrides or a per-directory .atlantis.project.yaml file.
+// Zero-valued fields are left unset by mergeProjectOverride.
+type ProjectOverride struct {
+	Glob                string           `yaml:"glob,omitempty"`
+	Workflow            string           `yaml:"workflow,omitempty"`
+	TerraformVersion    string           `yaml:"terraform_version,omitempty"`
+	ApplyRequirements   []string         `yaml:"apply_requirements,omitempty"`
+	ExecutionOrderGroup *int             `yaml:"execution_order_group,omitempty"`
+	RepoLocks           *RepoLocksConfig `yaml:"repo_locks,omitempty"`
+	Branch              string           `yaml:"branch,omitempty"`
+}
+
+// Read NUM_EXECUTORS from the environment, falling back to defaultNumExecutors if unset.
+func getNumExecutors() int {
+	raw := os.Getenv("NUM_EXECUTORS")
+	if raw == "" {
+		return defaultNumExecutors
+	}
+
+	numExecutors, err := strconv.Atoi(raw)
+	if err != nil || numExecutors < 1 {
+		log.Fatalf("NUM_EXECUTORS must be a positive integer, got %q", raw)
+	}
+
+	return numExecutors
+}
+
 // Get a list of projects and a map of path dependencies for each project.
 //
 // Walk the directory tree, starting at the root of the repository. For each directory:
@@ -91,6 +205,20 @@ func getProjectsAndDependencies() ([]string, map[string][]string) {
 			}
 		}
 
+		if fileExists(filepath.Join(path, terragruntConfigFilename)) {
+			if !slices.Contains(projects, path) {
+				projects = append(projects, path)
+			}
+
+			for _, dep := range getTerragruntDependencies(path) {
+				absPath := filepath.Join(path, dep)
+
+				if fileExists(absPath) && !slices.Contains(dependencies[path], dep) {
+					dependencies[path] = append(dependencies[path], dep)
+				}
+			}
+		}
+
 		return nil
 	})
 
@@ -101,6 +229,171 @@ func getProjectsAndDependencies() ([]string, map[string][]string) {
 	return projects, dependencies
 }
 
+// Parse a directory's terragrunt.hcl and return the relative paths it depends
+// on: one per `dependency "x" { config_path = "..." }` block, plus, unless
+// IGNORE_DEPENDENCY_BLOCKS is set, every path in a `dependencies { paths = [...] }`
+// block. These are fed into the same `dependencies` map that Terraform module
+// calls populate, so when_modified globs cover Terragrunt-declared deps too.
+func getTerragruntDependencies(path string) []string {
+	parser := hclparse.NewParser()
+
+	hclFile, diags := parser.ParseHCLFile(filepath.Join(path, terragruntConfigFilename))
+	if diags.HasErrors() {
+		log.Fatal(diags)
+	}
+
+	content, _, diags := hclFile.Body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{
+			{Type: "dependency", LabelNames: []string{"name"}},
+			{Type: "dependencies"},
+		},
+	})
+	if diags.HasErrors() {
+		log.Fatal(diags)
+	}
+
+	deps := []string{}
+
+	for _, block := range content.Blocks {
+		switch block.Type {
+		case "dependency":
+			if configPath, ok := getStringAttribute(block.Body, "config_path"); ok {
+				deps = append(deps, configPath)
+			}
+		case "dependencies":
+			if !IGNORE_DEPENDENCY_BLOCKS {
+				deps = append(deps, getStringListAttribute(block.Body, "paths")...)
+			}
+		}
+	}
+
+	return deps
+}
+
+// Read a string-valued attribute from an HCL body. Returns ok=false if the
+// attribute is absent or its expression can't be evaluated without a
+// function/variable context, e.g. a Terragrunt call like find_in_parent_folders().
+func getStringAttribute(body hcl.Body, name string) (string, bool) {
+	content, _, diags := body.PartialContent(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: name}},
+	})
+	if diags.HasErrors() {
+		return "", false
+	}
+
+	attr, ok := content.Attributes[name]
+	if !ok {
+		return "", false
+	}
+
+	value, diags := attr.Expr.Value(nil)
+	if diags.HasErrors() || value.Type() != cty.String {
+		return "", false
+	}
+
+	return value.AsString(), true
+}
+
+// Read a list-of-strings attribute from an HCL body, skipping the attribute
+// entirely if it's absent or can't be evaluated, and skipping any elements
+// that aren't plain strings.
+func getStringListAttribute(body hcl.Body, name string) []string {
+	content, _, diags := body.PartialContent(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: name}},
+	})
+	if diags.HasErrors() {
+		return nil
+	}
+
+	attr, ok := content.Attributes[name]
+	if !ok {
+		return nil
+	}
+
+	value, diags := attr.Expr.Value(nil)
+	if diags.HasErrors() || !value.CanIterateElements() {
+		return nil
+	}
+
+	values := []string{}
+	for _, element := range value.AsValueSlice() {
+		if element.Type() == cty.String {
+			values = append(values, element.AsString())
+		}
+	}
+
+	return values
+}
+
+// Build a directed graph over the directories in `dependencies`, with an edge
+// from a directory to each module directory it calls.
+//
+// Returns the graph together with the slice of directories indexed by vertex
+// ID, so that strongly connected components can be translated back into
+// directory paths.
+func buildDependencyGraph(dependencies map[string][]string) (*graph.Mutable, []string) {
+	dirs := make([]string, 0, len(dependencies))
+	for dir := range dependencies {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	vertexOf := make(map[string]int, len(dirs))
+	for i, dir := range dirs {
+		vertexOf[dir] = i
+	}
+
+	g := graph.New(len(dirs))
+
+	for dir, deps := range dependencies {
+		for _, dep := range deps {
+			depDir := filepath.Clean(filepath.Join(dir, dep))
+			if depVertex, ok := vertexOf[depDir]; ok {
+				g.Add(vertexOf[dir], depVertex)
+			}
+		}
+	}
+
+	return g, dirs
+}
+
+// Detect cycles in the module dependency graph, e.g. module A -> module B -> module A
+// via local path sources. Terraform's own module graph can't contain cycles, so rather
+// than recursing into one and blowing the stack while computing when_modified paths,
+// we fail fast here with the offending directories listed.
+func detectCycles(dependencies map[string][]string) error {
+	g, dirs := buildDependencyGraph(dependencies)
+
+	offending := map[string]bool{}
+
+	for _, component := range graph.StrongComponents(g) {
+		if len(component) > 1 {
+			for _, v := range component {
+				offending[dirs[v]] = true
+			}
+			continue
+		}
+
+		// A single-vertex component is still a cycle if the vertex has a self-loop.
+		v := component[0]
+		if g.Edge(v, v) {
+			offending[dirs[v]] = true
+		}
+	}
+
+	if len(offending) == 0 {
+		return nil
+	}
+
+	involved := make([]string, 0, len(offending))
+	for dir := range offending {
+		involved = append(involved, dir)
+	}
+	sort.Strings(involved)
+
+	return fmt.Errorf("found a cycle in module dependencies, involving: %s", strings.Join(involved, ", "))
+}
+
 // Read the contents of `atlantis.yaml` and reflect them into an AtlantisConfig struct.
 // `atlantis.yaml` is expected to exist at the path "${ROOT}/atlantis.yaml"
 func readAtlantisYaml() AtlantisConfig {
@@ -123,24 +416,35 @@ func readAtlantisYaml() AtlantisConfig {
 }
 
 // Add project configurations to the atlantis config.
-// This is done with goroutines because its easy and they make it go zoom zoom real fast.
-// Explanation here: https://gobyexample.com/waitgroups
+//
+// Projects are processed concurrently, bounded to NUM_EXECUTORS at a time, with each
+// worker writing to its own index of a pre-allocated slice. This keeps the output
+// deterministic and ordered the same as `projects`, and avoids having unbounded
+// goroutines race on a shared slice.
 func addProjectsToConfig(atlantisConfig AtlantisConfig, projects []string, dependencies map[string][]string) AtlantisConfig {
-	// If `projects` configurations exist already, overwrite them instead of appending to them.
-	atlantisConfig.Projects = []ProjectConfig{}
+	projectConfigs := make([]ProjectConfig, len(projects))
 
-	wg := sync.WaitGroup{}
-	wg.Add(len(projects))
+	g := new(errgroup.Group)
+	g.SetLimit(NUM_EXECUTORS)
 
-	for i := 0; i < len(projects); i++ {
-		go func(i int) {
-			projectConfig := makeProjectConfig(projects[i], dependencies)
-			atlantisConfig.Projects = append(atlantisConfig.Projects, projectConfig)
-			defer wg.Done()
-		}(i)
+	for i := range projects {
+		i := i
+		g.Go(func() error {
+			projectConfig, err := makeProjectConfig(projects[i], dependencies)
+			if err != nil {
+				return err
+			}
+			projectConfigs[i] = projectConfig
+			return nil
+		})
 	}
 
-	wg.Wait()
+	if err := g.Wait(); err != nil {
+		log.Fatal(err)
+	}
+
+	// If `projects` configurations exist already, overwrite them instead of appending to them.
+	atlantisConfig.Projects = projectConfigs
 	return atlantisConfig
 }
 
@@ -157,67 +461,178 @@ func fileExists(path string) bool {
 }
 
 // Make the project configurations for a single project.
-func makeProjectConfig(project string, dependencies map[string][]string) ProjectConfig {
+func makeProjectConfig(project string, dependencies map[string][]string) (ProjectConfig, error) {
 	whenModifiedPaths := getWhenModifiedPaths(project, dependencies)
-	cleanedPaths := cleanPaths(whenModifiedPaths, project)
+	cleanedPaths := cleanPaths(whenModifiedPaths)
 	projectRelativePath := strings.Replace(project, ROOT+"/", "", 1)
 
 	projectConfig := ProjectConfig{
 		Autoplan: AutoplanConfig{
-			Enabled:      true,
+			Enabled:      AUTOPLAN_ENABLED,
 			WhenModified: cleanedPaths,
 		},
-		Dir:  projectRelativePath,
-		Name: projectRelativePath,
+		Dir:      projectRelativePath,
+		Name:     projectRelativePath,
+		Workflow: WORKFLOW,
+	}
+
+	return applyOverrides(projectConfig, project)
+}
+
+// Layer CONFIG's defaults, the first matching glob override in
+// CONFIG.Overrides, and a per-directory .atlantis.project.yaml file (most
+// specific wins) onto a generated project config.
+func applyOverrides(projectConfig ProjectConfig, project string) (ProjectConfig, error) {
+	if CONFIG != nil {
+		projectConfig = mergeProjectOverride(projectConfig, CONFIG.Defaults)
+
+		projectRelativePath := strings.Replace(project, ROOT+"/", "", 1)
+
+		for _, override := range CONFIG.Overrides {
+			if matched, _ := doublestar.Match(override.Glob, projectRelativePath); matched {
+				projectConfig = mergeProjectOverride(projectConfig, override)
+				break
+			}
+		}
+	}
+
+	overridePath := filepath.Join(project, projectOverrideFilename)
+
+	if fileExists(overridePath) {
+		file, err := ioutil.ReadFile(overridePath)
+		if err != nil {
+			return ProjectConfig{}, err
+		}
+
+		var override ProjectOverride
+		if err := yaml.Unmarshal(file, &override); err != nil {
+			return ProjectConfig{}, err
+		}
+
+		projectConfig = mergeProjectOverride(projectConfig, override)
+	}
+
+	return projectConfig, nil
+}
+
+// Apply the non-zero fields of `override` onto `projectConfig`, leaving
+// fields that `override` doesn't set untouched.
+func mergeProjectOverride(projectConfig ProjectConfig, override ProjectOverride) ProjectConfig {
+	if override.Workflow != "" {
+		projectConfig.Workflow = override.Workflow
+	}
+	if override.TerraformVersion != "" {
+		projectConfig.TerraformVersion = override.TerraformVersion
+	}
+	if override.ApplyRequirements != nil {
+		projectConfig.ApplyRequirements = override.ApplyRequirements
+	}
+	if override.ExecutionOrderGroup != nil {
+		projectConfig.ExecutionOrderGroup = override.ExecutionOrderGroup
+	}
+	if override.RepoLocks != nil {
+		projectConfig.RepoLocks = override.RepoLocks
+	}
+	if override.Branch != "" {
+		projectConfig.Branch = override.Branch
 	}
 
 	return projectConfig
 }
 
+// Read and parse the --config input file. Returns nil if path is empty, so
+// that CONFIG-based overrides are a no-op unless --config was passed.
+func loadGeneratorConfig(path string) *GeneratorConfig {
+	if path == "" {
+		return nil
+	}
+
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var config GeneratorConfig
+	if err := yaml.Unmarshal(file, &config); err != nil {
+		log.Fatal(err)
+	}
+
+	return &config
+}
+
+// Narrow `projects` down to those whose path, relative to ROOT, matches the
+// glob pattern `filter`. An empty filter returns `projects` unchanged.
+func filterProjectsByGlob(projects []string, filter string) []string {
+	if filter == "" {
+		return projects
+	}
+
+	filtered := []string{}
+
+	for _, project := range projects {
+		projectRelativePath := strings.Replace(project, ROOT+"/", "", 1)
+
+		if matched, _ := doublestar.Match(filter, projectRelativePath); matched {
+			filtered = append(filtered, project)
+		}
+	}
+
+	return filtered
+}
+
 // For a given project, list the relative paths from that project's directory
 // to the directories containing modules that the project depends on.
 //
-// This is done recursively. When a module directory is identified as a path dependency,
-// we also check for dependencies of that module, and so on, since changes to those
-// submodules may affect the resources managed by the project.
+// This walks the dependency graph breadth-first, starting at the project
+// directory and tracking a visited set so that revisiting the same module
+// through two different call chains doesn't cause duplicate work (or, on a
+// cyclic graph, infinite recursion). detectCycles is expected to have already
+// rejected any cycle before this runs.
 //
-// The paths returned by this function are kinda gross (e.g. "abs/path/to/project1/../modules/module1/../module2")
-// This is because we can't clean the paths while the function recurses.
-// The cleaning is done after the full list is generated.
-func getWhenModifiedPaths(path string, dependencies map[string][]string) []string {
+// Each reachable module contributes a single clean relative path, computed as
+// we go, rather than the "gross" concatenated paths a naive recursion would
+// produce (e.g. "project1/../modules/module1/../module2").
+func getWhenModifiedPaths(project string, dependencies map[string][]string) []string {
 	paths := []string{}
+	visited := map[string]bool{filepath.Clean(project): true}
+
+	type reachableDir struct {
+		path         string // absolute path to the directory
+		relativePath string // path to this directory, relative to `project`
+	}
+
+	queue := []reachableDir{{path: project, relativePath: "."}}
 
-	// If we are recursing, `path` represents a potentially messy
-	// absolute path to a module that our project depends on.
-	// (e.g. "abs/path/to/project1/../modules/module1/../module2")
-	//
-	// The `dependencies` map is keyed with relative paths from the root of the repository
-	// (e.g. `modules/module2`), so we reformat `path` to match that format.
-	cleanPath := filepath.Clean(path)
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
 
-	for _, dep := range dependencies[cleanPath] {
-		paths = append(paths, path+"/"+dep+"/**/*")
-		paths = append(paths, getWhenModifiedPaths(path+"/"+dep, dependencies)...)
+		for _, dep := range dependencies[filepath.Clean(dir.path)] {
+			depPath := filepath.Clean(filepath.Join(dir.path, dep))
+			depRelativePath := filepath.Clean(filepath.Join(dir.relativePath, dep))
+
+			if visited[depPath] {
+				continue
+			}
+			visited[depPath] = true
+			paths = append(paths, depRelativePath+"/**/*")
+			queue = append(queue, reachableDir{path: depPath, relativePath: depRelativePath})
+		}
 	}
 
 	return paths
 }
 
-// Take the paths generated for a project by getWhenModifiedPaths,
-// - ("abs/path/to/project1/../modules/module1/../module2")
+// Take the relative paths generated for a project by getWhenModifiedPaths and
+// deduplicate, sort, and append the project's own directory to them.
 //
-// and turn them into relative paths from the project directory with wildcards.
-// - ("../modules/module2/**/*")
-//
-// We add wildcards because we want to autoplan based on changes to any files
-// in any subdirectories of each module, in addition to the root directory.
-func cleanPaths(paths []string, project string) []string {
+// The "**/*" appended here covers any file in the project directory and its
+// subdirectories; getWhenModifiedPaths already ends each module path with "/**/*".
+func cleanPaths(paths []string) []string {
 	cleanedPaths := []string{}
 
 	for _, path := range paths {
-		cleanedPath := strings.Replace(path, project+"/", "", 1)
-		cleanedPath = filepath.Clean(cleanedPath)
-		cleanedPaths = append(cleanedPaths, cleanedPath)
+		cleanedPaths = append(cleanedPaths, filepath.Clean(path))
 	}
 
 	cleanedPaths = append(cleanedPaths, "**/*")
@@ -227,6 +642,97 @@ func cleanPaths(paths []string, project string) []string {
 	return cleanedPaths
 }
 
+// List the absolute directories that a project depends on, directly or
+// transitively, plus the project's own directory.
+//
+// This walks the dependency graph the same way getWhenModifiedPaths does, but
+// returns the raw directories instead of when_modified globs, since that's
+// what's needed to tell whether a changed file affects the project.
+func getDependencyClosure(project string, dependencies map[string][]string) []string {
+	closure := []string{filepath.Clean(project)}
+	visited := map[string]bool{filepath.Clean(project): true}
+	queue := []string{project}
+
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+
+		for _, dep := range dependencies[filepath.Clean(dir)] {
+			depPath := filepath.Clean(filepath.Join(dir, dep))
+
+			if visited[depPath] {
+				continue
+			}
+			visited[depPath] = true
+			closure = append(closure, depPath)
+			queue = append(queue, depPath)
+		}
+	}
+
+	return closure
+}
+
+// Using `git diff --name-only`, list the files that changed between BASE_REF
+// and HEAD, as paths relative to the root of the repository.
+func getChangedFiles() []string {
+	cmd := exec.Command("git", "diff", "--name-only", BASE_REF+"..HEAD")
+	cmd.Dir = ROOT
+
+	output, err := cmd.Output()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	trimmed := strings.TrimSpace(string(output))
+	if trimmed == "" {
+		return []string{}
+	}
+
+	return strings.Split(trimmed, "\n")
+}
+
+// Return true if any of the changed files falls under the project's own
+// directory or the directory of a module it depends on, directly or
+// transitively.
+func projectHasChanges(project string, dependencies map[string][]string, changedFiles []string) bool {
+	for _, dir := range getDependencyClosure(project, dependencies) {
+		pattern := dir + "/**/*"
+
+		for _, changedFile := range changedFiles {
+			absChangedFile := filepath.Join(ROOT, changedFile)
+
+			if matched, _ := doublestar.Match(pattern, absChangedFile); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Narrow `projects` down to those affected by the files changed since BASE_REF.
+//
+// This is "smart mode": drop it into an Atlantis pre-workflow hook, set BASE_REF
+// to the pull request's base branch, and Atlantis will only plan projects that
+// are actually impacted by the diff. If BASE_REF isn't set, smart mode is
+// disabled and every project is returned unchanged.
+func filterChangedProjects(projects []string, dependencies map[string][]string) []string {
+	if BASE_REF == "" {
+		return projects
+	}
+
+	changedFiles := getChangedFiles()
+	filtered := []string{}
+
+	for _, project := range projects {
+		if projectHasChanges(project, dependencies, changedFiles) {
+			filtered = append(filtered, project)
+		}
+	}
+
+	return filtered
+}
+
 // Take a list of file paths and return the same list without duplicates.
 func unique(paths []string) []string {
 	allKeys := make(map[string]bool)
@@ -261,15 +767,92 @@ func writeAtlantisYaml(atlantisConfig AtlantisConfig) {
 // Walk the repository and gather a list of project directories
 // and a map of their dependencies (also directories).
 //
+// Reject the dependency graph if it contains a cycle.
+//
+// If BASE_REF is set, narrow the projects down to those affected by the
+// files changed since BASE_REF. If FILTER is set, narrow them down further
+// to those matching the glob.
+//
 // Load content of `atlantis.yaml` into a struct.
 // Add autoplan configurations for each project.
 //
 // Encode contents into yaml and write it back to the file.
-func main() {
+func generate() error {
 	projects, dependencies := getProjectsAndDependencies()
 
+	if err := detectCycles(dependencies); err != nil {
+		return err
+	}
+
+	projects = filterChangedProjects(projects, dependencies)
+	projects = filterProjectsByGlob(projects, FILTER)
+
 	atlantisConfig := readAtlantisYaml()
+
+	if PARALLEL_PLAN_OVERRIDE != nil {
+		atlantisConfig.ParallelPlan = *PARALLEL_PLAN_OVERRIDE
+	}
+	if PARALLEL_APPLY_OVERRIDE != nil {
+		atlantisConfig.ParallelApply = *PARALLEL_APPLY_OVERRIDE
+	}
+	if CONFIG != nil && CONFIG.Workflows != nil {
+		atlantisConfig.Workflows = CONFIG.Workflows
+	}
+
 	atlantisConfigComplete := addProjectsToConfig(atlantisConfig, projects, dependencies)
 
 	writeAtlantisYaml(atlantisConfigComplete)
+
+	return nil
+}
+
+// Build the generate_atlantis_yaml CLI. Most flags bind directly to the
+// package-level configuration variables they control; --ignore-dir,
+// --parallel-plan, --parallel-apply and --config are applied when the command
+// runs. --root and --num-executors default to the DIR and NUM_EXECUTORS
+// environment variables, so existing env-var-driven usage (e.g. from an
+// Atlantis pre-workflow hook) keeps working unchanged.
+func newRootCmd() *cobra.Command {
+	var ignoreDirFlags []string
+	var parallelPlan, parallelApply bool
+	var configPath string
+
+	cmd := &cobra.Command{
+		Use:          "generate_atlantis_yaml",
+		Short:        "Generate an atlantis.yaml with autoplan configuration for every Terraform project in a repository",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			IGNORE_DIRS = append(IGNORE_DIRS, ignoreDirFlags...)
+
+			if cmd.Flags().Changed("parallel-plan") {
+				PARALLEL_PLAN_OVERRIDE = &parallelPlan
+			}
+			if cmd.Flags().Changed("parallel-apply") {
+				PARALLEL_APPLY_OVERRIDE = &parallelApply
+			}
+
+			CONFIG = loadGeneratorConfig(configPath)
+
+			return generate()
+		},
+	}
+
+	cmd.Flags().StringVar(&ROOT, "root", ROOT, "Root directory of the repository to walk (falls back to the DIR env var)")
+	cmd.Flags().StringVar(&FILTER, "filter", FILTER, "Glob pattern, relative to --root, to scope generation to a subtree")
+	cmd.Flags().StringArrayVar(&ignoreDirFlags, "ignore-dir", nil, "Directory name to ignore when walking the repo, in addition to the defaults (repeatable)")
+	cmd.Flags().StringVar(&WORKFLOW, "workflow", WORKFLOW, "Atlantis workflow to attach to every generated project")
+	cmd.Flags().BoolVar(&AUTOPLAN_ENABLED, "autoplan", AUTOPLAN_ENABLED, "Enable autoplan for generated projects")
+	cmd.Flags().BoolVar(&parallelPlan, "parallel-plan", false, "Override parallel_plan in the generated atlantis.yaml")
+	cmd.Flags().BoolVar(&parallelApply, "parallel-apply", false, "Override parallel_apply in the generated atlantis.yaml")
+	cmd.Flags().StringVar(&configPath, "config", "", "Path to a generator config file with defaults and glob-matched per-project overrides")
+	cmd.Flags().BoolVar(&IGNORE_DEPENDENCY_BLOCKS, "ignore-dependency-blocks", IGNORE_DEPENDENCY_BLOCKS, "Ignore terragrunt.hcl `dependencies { paths = [...] }` blocks when building the dependency graph")
+	cmd.Flags().IntVar(&NUM_EXECUTORS, "num-executors", NUM_EXECUTORS, "Maximum number of projects to process concurrently")
+
+	return cmd
+}
+
+func main() {
+	if err := newRootCmd().Execute(); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -45,9 +45,17 @@ func revertAtlantisYaml(absPath string) {
 func TestGetProjectsAndDependencies(t *testing.T) {
 	absPath := prepEnv(t)
 
+	// Keep the cyclic and Terragrunt fixtures under test_data/fixtures out of this walk.
+	originalIgnoreDirs := IGNORE_DIRS
+	IGNORE_DIRS = append(append([]string{}, IGNORE_DIRS...), "fixtures")
+	defer func() { IGNORE_DIRS = originalIgnoreDirs }()
+
 	gotProjects, gotDeps := getProjectsAndDependencies()
 
-	expectedProjects := []string{absPath + "/project1", absPath + "/project2"}
+	expectedProjects := []string{
+		absPath + "/project1",
+		absPath + "/project2",
+	}
 	expectedDeps := map[string][]string{
 		absPath:                            {},
 		absPath + "/project1":              {"../modules/module1"},
@@ -199,7 +207,10 @@ func TestMakeProjectConfig(t *testing.T) {
 		absPath + "/modules/module1": {"../module2"},
 	}
 
-	gotConfig := makeProjectConfig(absPath+"/project1", dependencies)
+	gotConfig, err := makeProjectConfig(absPath+"/project1", dependencies)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	expectedConfig := ProjectConfig{
 		Name: "project1",
@@ -222,6 +233,95 @@ func TestMakeProjectConfig(t *testing.T) {
 	}
 }
 
+func TestMergeProjectOverride(t *testing.T) {
+	executionOrderGroup := 2
+
+	projectConfig := ProjectConfig{
+		Name:     "project1",
+		Dir:      "project1",
+		Workflow: "default",
+	}
+
+	override := ProjectOverride{
+		TerraformVersion:    "1.6.0",
+		ExecutionOrderGroup: &executionOrderGroup,
+		RepoLocks:           &RepoLocksConfig{Mode: "none"},
+	}
+
+	gotConfig := mergeProjectOverride(projectConfig, override)
+
+	expectedConfig := ProjectConfig{
+		Name:                "project1",
+		Dir:                 "project1",
+		Workflow:            "default",
+		TerraformVersion:    "1.6.0",
+		ExecutionOrderGroup: &executionOrderGroup,
+		RepoLocks:           &RepoLocksConfig{Mode: "none"},
+	}
+
+	if !reflect.DeepEqual(expectedConfig, gotConfig) {
+		t.Errorf(strings.Join([]string{
+			"Expected project config:\n%s\n",
+			"Got project config:\n%s",
+		}, "\n"), prettyPrint(expectedConfig), prettyPrint(gotConfig))
+	}
+}
+
+func TestApplyOverridesWithConfig(t *testing.T) {
+	originalRoot := ROOT
+	ROOT = "/repo"
+	defer func() { ROOT = originalRoot }()
+
+	originalConfig := CONFIG
+	defer func() { CONFIG = originalConfig }()
+
+	CONFIG = &GeneratorConfig{
+		Defaults: ProjectOverride{TerraformVersion: "1.5.0"},
+		Overrides: []ProjectOverride{
+			{Glob: "project1", Workflow: "custom"},
+		},
+	}
+
+	projectConfig := ProjectConfig{Name: "project1", Dir: "project1"}
+
+	gotConfig, err := applyOverrides(projectConfig, "/repo/project1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedConfig := ProjectConfig{
+		Name:             "project1",
+		Dir:              "project1",
+		Workflow:         "custom",
+		TerraformVersion: "1.5.0",
+	}
+
+	if !reflect.DeepEqual(expectedConfig, gotConfig) {
+		t.Errorf(strings.Join([]string{
+			"Expected project config:\n%s\n",
+			"Got project config:\n%s",
+		}, "\n"), prettyPrint(expectedConfig), prettyPrint(gotConfig))
+	}
+}
+
+func TestApplyOverridesWithInvalidOverrideFile(t *testing.T) {
+	originalRoot := ROOT
+	ROOT = "/repo"
+	defer func() { ROOT = originalRoot }()
+
+	tmpDir := t.TempDir()
+	overridePath := filepath.Join(tmpDir, projectOverrideFilename)
+	if err := ioutil.WriteFile(overridePath, []byte("not: [valid yaml"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	projectConfig := ProjectConfig{Name: "project1", Dir: "project1"}
+
+	if _, err := applyOverrides(projectConfig, tmpDir); err == nil {
+		t.Error("Expected an error from a malformed override file, got nil")
+	}
+}
+
 func TestGetWhenModifiedPaths(t *testing.T) {
 	absPath := prepEnv(t)
 
@@ -235,8 +335,8 @@ func TestGetWhenModifiedPaths(t *testing.T) {
 	gotPaths := getWhenModifiedPaths(absPath+"/project1", dependencies)
 
 	expectedPaths := []string{
-		absPath + "/project1/../modules/module1/**/*",
-		absPath + "/project1/../modules/module1/../module2/**/*",
+		"../modules/module1/**/*",
+		"../modules/module2/**/*",
 	}
 
 	if !reflect.DeepEqual(expectedPaths, gotPaths) {
@@ -248,11 +348,9 @@ func TestGetWhenModifiedPaths(t *testing.T) {
 }
 
 func TestCleanPaths(t *testing.T) {
-	absPath := prepEnv(t)
-
 	dirtyPaths := []string{
-		absPath + "/project1/../modules/module1/**/*",
-		absPath + "/project1/../modules/module1/../module2/**/*",
+		"../modules/module1/**/*",
+		"../modules/./module2/**/*",
 	}
 
 	expectedPaths := []string{
@@ -261,7 +359,7 @@ func TestCleanPaths(t *testing.T) {
 		"../modules/module2/**/*",
 	}
 
-	gotPaths := cleanPaths(dirtyPaths, absPath+"/project1")
+	gotPaths := cleanPaths(dirtyPaths)
 
 	if !reflect.DeepEqual(expectedPaths, gotPaths) {
 		t.Errorf(strings.Join([]string{
@@ -271,6 +369,188 @@ func TestCleanPaths(t *testing.T) {
 	}
 }
 
+func TestDetectCycles(t *testing.T) {
+	absPath := prepEnv(t)
+
+	dependencies := map[string][]string{
+		absPath + "/project1":        {"../modules/module1"},
+		absPath + "/modules/module1": {"../module2"},
+		absPath + "/modules/module2": {},
+	}
+
+	if err := detectCycles(dependencies); err != nil {
+		t.Errorf("Expected no cycle to be detected, got error: %v", err)
+	}
+}
+
+func TestDetectCyclesWithFixture(t *testing.T) {
+	absPath, err := filepath.Abs("./test_data/fixtures/cyclic")
+
+	if err != nil {
+		t.Error("Cannot find test data")
+	}
+
+	originalRoot := ROOT
+	ROOT = absPath
+	defer func() { ROOT = originalRoot }()
+
+	_, dependencies := getProjectsAndDependencies()
+
+	err = detectCycles(dependencies)
+	if err == nil {
+		t.Fatal("Expected a cycle to be detected, got nil")
+	}
+
+	for _, dir := range []string{absPath + "/a", absPath + "/b"} {
+		if !strings.Contains(err.Error(), dir) {
+			t.Errorf("Expected cycle error to mention %q, got: %v", dir, err)
+		}
+	}
+}
+
+func TestGetTerragruntDependencies(t *testing.T) {
+	absPath, err := filepath.Abs("./test_data/fixtures/terragrunt/app")
+
+	if err != nil {
+		t.Error("Cannot find test data")
+	}
+
+	gotDeps := getTerragruntDependencies(absPath)
+
+	expectedDeps := []string{"../vpc", "../vpc", "../db"}
+
+	if !reflect.DeepEqual(expectedDeps, gotDeps) {
+		t.Errorf(strings.Join([]string{
+			"Expected deps:\n%s\n",
+			"Got deps:\n%s",
+		}, "\n"), prettyPrint(expectedDeps), prettyPrint(gotDeps))
+	}
+}
+
+func TestGetTerragruntDependenciesIgnoreDependencyBlocks(t *testing.T) {
+	originalIgnore := IGNORE_DEPENDENCY_BLOCKS
+	IGNORE_DEPENDENCY_BLOCKS = true
+	defer func() { IGNORE_DEPENDENCY_BLOCKS = originalIgnore }()
+
+	absPath, err := filepath.Abs("./test_data/fixtures/terragrunt/app")
+
+	if err != nil {
+		t.Error("Cannot find test data")
+	}
+
+	gotDeps := getTerragruntDependencies(absPath)
+
+	expectedDeps := []string{"../vpc"}
+
+	if !reflect.DeepEqual(expectedDeps, gotDeps) {
+		t.Errorf(strings.Join([]string{
+			"Expected deps:\n%s\n",
+			"Got deps:\n%s",
+		}, "\n"), prettyPrint(expectedDeps), prettyPrint(gotDeps))
+	}
+}
+
+func TestGetDependencyClosure(t *testing.T) {
+	absPath := prepEnv(t)
+
+	dependencies := map[string][]string{
+		absPath + "/project1":        {"../modules/module1"},
+		absPath + "/modules/module1": {"../module2"},
+		absPath + "/modules/module2": {},
+	}
+
+	gotClosure := getDependencyClosure(absPath+"/project1", dependencies)
+
+	expectedClosure := []string{
+		absPath + "/project1",
+		absPath + "/modules/module1",
+		absPath + "/modules/module2",
+	}
+
+	if !reflect.DeepEqual(expectedClosure, gotClosure) {
+		t.Errorf(strings.Join([]string{
+			"Expected closure:\n%s\n",
+			"Got closure:\n%s",
+		}, "\n"), prettyPrint(expectedClosure), prettyPrint(gotClosure))
+	}
+}
+
+func TestProjectHasChanges(t *testing.T) {
+	absPath := prepEnv(t)
+
+	dependencies := map[string][]string{
+		absPath + "/project1":        {"../modules/module1"},
+		absPath + "/project2":        {"../modules/module2"},
+		absPath + "/modules/module1": {"../module2"},
+		absPath + "/modules/module2": {},
+	}
+
+	cases := []struct {
+		name          string
+		project       string
+		changedFiles  []string
+		expectChanged bool
+	}{
+		{"change in project dir", absPath + "/project1", []string{"project1/main.tf"}, true},
+		{"change in direct dependency", absPath + "/project1", []string{"modules/module1/main.tf"}, true},
+		{"change in transitive dependency", absPath + "/project1", []string{"modules/module2/main.tf"}, true},
+		{"change in unrelated project", absPath + "/project1", []string{"project2/main.tf"}, false},
+		{"no changes", absPath + "/project1", []string{}, false},
+	}
+
+	for _, c := range cases {
+		got := projectHasChanges(c.project, dependencies, c.changedFiles)
+		if got != c.expectChanged {
+			t.Errorf("%s: projectHasChanges() = %v, want %v", c.name, got, c.expectChanged)
+		}
+	}
+}
+
+func TestFilterChangedProjectsNoBaseRef(t *testing.T) {
+	projects := []string{"/project1", "/project2"}
+
+	gotProjects := filterChangedProjects(projects, map[string][]string{})
+
+	if !reflect.DeepEqual(projects, gotProjects) {
+		t.Errorf(strings.Join([]string{
+			"Expected projects:\n%s\n",
+			"Got projects:\n%s",
+		}, "\n"), prettyPrint(projects), prettyPrint(gotProjects))
+	}
+}
+
+func TestFilterProjectsByGlob(t *testing.T) {
+	originalRoot := ROOT
+	ROOT = "/repo"
+	defer func() { ROOT = originalRoot }()
+
+	projects := []string{"/repo/services/api", "/repo/services/web", "/repo/modules/module1"}
+
+	expected := []string{"/repo/services/api", "/repo/services/web"}
+
+	got := filterProjectsByGlob(projects, "services/*")
+
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf(strings.Join([]string{
+			"Expected projects:\n%s\n",
+			"Got projects:\n%s",
+		}, "\n"), prettyPrint(expected), prettyPrint(got))
+	}
+}
+
+func TestFilterProjectsByGlobEmpty(t *testing.T) {
+	projects := []string{"/repo/services/api", "/repo/services/web"}
+
+	got := filterProjectsByGlob(projects, "")
+
+	if !reflect.DeepEqual(projects, got) {
+		t.Errorf(strings.Join([]string{
+			"Expected projects:\n%s\n",
+			"Got projects:\n%s",
+		}, "\n"), prettyPrint(projects), prettyPrint(got))
+	}
+}
+
 func TestUnique(t *testing.T) {
 	dupes := []string{"thing 1", "thing 2", "thing 2"}
 
@@ -350,7 +630,14 @@ func TestMain(t *testing.T) {
 		"version: 3\n",
 	}, "\n")
 
-	main()
+	// Keep the cyclic and Terragrunt fixtures under test_data/fixtures out of this walk.
+	originalIgnoreDirs := IGNORE_DIRS
+	IGNORE_DIRS = append(append([]string{}, IGNORE_DIRS...), "fixtures")
+	defer func() { IGNORE_DIRS = originalIgnoreDirs }()
+
+	if err := generate(); err != nil {
+		t.Fatal(err)
+	}
 
 	gotYaml, err := ioutil.ReadFile(absPath + "/atlantis.yaml")
 